@@ -0,0 +1,88 @@
+// Copyright (c) 2013-2019 by Michael Dvorkin and contributors. All Rights Reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package mop
+
+import (
+	"fmt"
+
+	"github.com/Knetic/govaluate"
+)
+
+// filterVariables declares the identifiers a filter expression is allowed
+// to reference, along with their type, so SetFilter/ValidateFilter can
+// reject typos and references to nonexistent quote fields before they
+// ever get persisted.
+var filterVariables = map[string]string{
+	`price`:      `float64`,
+	`change`:     `float64`,
+	`pct_change`: `float64`,
+	`volume`:     `float64`,
+	`mcap`:       `float64`,
+}
+
+// filterFunctions is the set of functions a filter expression may call.
+// It is intentionally empty: filters are meant to be simple comparisons
+// over quote fields, and govaluate rejects a call to any function not in
+// this map as a parse error.
+var filterFunctions = map[string]govaluate.ExpressionFunction{}
+
+// ValidateFilter reports whether filter is safe to compile and persist:
+// it must parse as a govaluate expression, call only whitelisted
+// functions, and reference only the variables declared in
+// filterVariables. The UI should call this before committing a filter to
+// the profile.
+func (profile *Profile) ValidateFilter(filter string) error {
+	if len(filter) == 0 {
+		return nil
+	}
+
+	expression, err := govaluate.NewEvaluableExpressionWithFunctions(filter, filterFunctions)
+	if err != nil {
+		return fmt.Errorf(`mop: invalid filter expression: %v`, err)
+	}
+
+	for _, variable := range expression.Vars() {
+		if _, declared := filterVariables[variable]; !declared {
+			return fmt.Errorf(`mop: filter references unknown variable %q`, variable)
+		}
+	}
+
+	return nil
+}
+
+// SaveNamedFilter validates filter and stores it under name so it can
+// later be reapplied with ApplyNamedFilter.
+func (profile *Profile) SaveNamedFilter(name string, filter string) error {
+	if err := profile.ValidateFilter(filter); err != nil {
+		return err
+	}
+
+	if profile.NamedFilters == nil {
+		profile.NamedFilters = make(map[string]string)
+	}
+	profile.NamedFilters[name] = filter
+
+	return profile.Save()
+}
+
+// RemoveNamedFilter deletes the named filter preset, if any.
+func (profile *Profile) RemoveNamedFilter(name string) error {
+	if _, exists := profile.NamedFilters[name]; !exists {
+		return fmt.Errorf(`mop: no saved filter named %q`, name)
+	}
+
+	delete(profile.NamedFilters, name)
+	return profile.Save()
+}
+
+// ApplyNamedFilter makes the named filter preset the active filter.
+func (profile *Profile) ApplyNamedFilter(name string) error {
+	filter, exists := profile.NamedFilters[name]
+	if !exists {
+		return fmt.Errorf(`mop: no saved filter named %q`, name)
+	}
+
+	return profile.SetFilter(filter)
+}
@@ -0,0 +1,25 @@
+// Copyright (c) 2013-2019 by Michael Dvorkin and contributors. All Rights Reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package mop
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an advisory exclusive flock(2) on file, blocking until
+// it's available. This keeps two mop instances from writing ~/.moprc at
+// the same time.
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
+
+// unlockFile releases a lock taken by lockFile.
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,22 @@
+// Copyright (c) 2013-2019 by Michael Dvorkin and contributors. All Rights Reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+//go:build windows
+// +build windows
+
+package mop
+
+import "os"
+
+// lockFile is a no-op on windows: mop's single-user, single-instance
+// usage there hasn't justified bringing in LockFileEx for an advisory
+// lock that flock(2) gets unix for free.
+func lockFile(file *os.File) error {
+	return nil
+}
+
+// unlockFile mirrors lockFile: nothing to release.
+func unlockFile(file *os.File) error {
+	return nil
+}
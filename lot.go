@@ -0,0 +1,296 @@
+// Copyright (c) 2013-2019 by Michael Dvorkin and contributors. All Rights Reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package mop
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Lot is a single purchase or sale of a ticker. A positive Count records
+// a buy; a negative Count records a sell at Price. Cost-basis and
+// realized P&L calculations replay a ticker's Lots in Date order.
+type Lot struct {
+	Date     time.Time
+	Price    float64
+	Count    int
+	Currency string
+	Fee      float64
+}
+
+const (
+	MethodFIFO    = `fifo`
+	MethodAverage = `average`
+)
+
+// FXConverter converts an amount in one currency into another. It is the
+// seam that lets Profile consolidate a multi-currency portfolio into
+// BaseCurrency without hardcoding a rate source.
+type FXConverter interface {
+	Convert(amount float64, from, to string) (float64, error)
+}
+
+// identityFXConverter is the default FXConverter: it only "converts"
+// between identical currencies, which is enough for single-currency
+// portfolios and keeps Profile usable without configuring real FX rates.
+type identityFXConverter struct{}
+
+func (identityFXConverter) Convert(amount float64, from, to string) (float64, error) {
+	if from != to {
+		return 0, fmt.Errorf(`mop: no FX rate available to convert %s to %s`, from, to)
+	}
+	return amount, nil
+}
+
+// SetFXConverter installs the FXConverter used to consolidate lots
+// denominated in a currency other than profile.BaseCurrency.
+func (profile *Profile) SetFXConverter(converter FXConverter) {
+	profile.fxConverter = converter
+}
+
+// AddLot records a purchase or sale of ticker and appends it to the
+// share's lot history, creating the Share if this is the first time the
+// ticker is seen. Lots are kept sorted by Date so FIFO/average-cost
+// replay in RealizedPnL sees them in chronological order.
+func (profile *Profile) AddLot(ticker string, lot Lot) error {
+	if profile.Shares == nil {
+		profile.Shares = make(map[string]*Share)
+	}
+
+	share, exists := profile.Shares[ticker]
+	if !exists {
+		share = &Share{}
+		profile.Shares[ticker] = share
+	}
+
+	share.Lots = append(share.Lots, lot)
+	sort.SliceStable(share.Lots, func(i, j int) bool {
+		return share.Lots[i].Date.Before(share.Lots[j].Date)
+	})
+
+	profile.recalculateShare(ticker)
+	return profile.Save()
+}
+
+// RemoveLot deletes the lot at index from ticker's history, e.g. to
+// correct a mis-entered trade.
+func (profile *Profile) RemoveLot(ticker string, index int) error {
+	share, exists := profile.Shares[ticker]
+	if !exists {
+		return fmt.Errorf(`mop: no shares tracked for %s`, ticker)
+	}
+	if index < 0 || index >= len(share.Lots) {
+		return fmt.Errorf(`mop: lot index %d out of range for %s`, index, ticker)
+	}
+
+	share.Lots = append(share.Lots[:index], share.Lots[index+1:]...)
+
+	profile.recalculateShare(ticker)
+	return profile.Save()
+}
+
+// recalculateShare refreshes the legacy Trade/Count summary fields from
+// the current open lots (buys not yet offset by a sell), so older UI code
+// that only knows about Share.Trade/Share.Count keeps showing a sane
+// average cost and position size.
+func (profile *Profile) recalculateShare(ticker string) {
+	share, exists := profile.Shares[ticker]
+	if !exists {
+		return
+	}
+
+	totalCost, totalCount := 0.0, 0
+	for _, lot := range openLots(share.Lots) {
+		totalCost += lot.Price * float64(lot.Count)
+		totalCount += lot.Count
+	}
+
+	share.Count = totalCount
+	if totalCount > 0 {
+		share.Trade = totalCost / float64(totalCount)
+	} else {
+		share.Trade = 0 // No open position left: don't show a stale average cost.
+	}
+}
+
+// openLots replays lots in order and FIFO-consumes sells (negative Count)
+// against preceding buys, returning only the still-open buy lots. It
+// operates on copies, so it never mutates share.Lots itself.
+func openLots(lots []Lot) []Lot {
+	open := make([]Lot, 0, len(lots))
+	for _, lot := range lots {
+		if lot.Count > 0 {
+			open = append(open, lot)
+			continue
+		}
+
+		toSell := -lot.Count
+		for toSell > 0 && len(open) > 0 {
+			head := &open[0]
+			used := head.Count
+			if used > toSell {
+				used = toSell
+			}
+			head.Count -= used
+			toSell -= used
+			if head.Count == 0 {
+				open = open[1:]
+			}
+		}
+	}
+	return open
+}
+
+// RealizedPnL replays ticker's lot history and returns the realized
+// profit or loss from every sell (negative Count) recorded so far, using
+// either FIFO or average-cost to determine the sold shares' cost basis.
+func (profile *Profile) RealizedPnL(ticker string, method string) (float64, error) {
+	if method != MethodFIFO && method != MethodAverage {
+		return 0, fmt.Errorf(`mop: unknown cost-basis method %q`, method)
+	}
+
+	share, exists := profile.Shares[ticker]
+	if !exists {
+		return 0, fmt.Errorf(`mop: no shares tracked for %s`, ticker)
+	}
+
+	// open holds the not-yet-sold buy lots, in chronological order.
+	open := make([]Lot, 0, len(share.Lots))
+	realized := 0.0
+
+	for _, lot := range share.Lots {
+		if lot.Count > 0 {
+			open = append(open, lot)
+			continue
+		}
+
+		toSell := -lot.Count
+		proceeds, err := profile.toBaseCurrency(lot.Price*float64(toSell)-lot.Fee, lot.Currency)
+		if err != nil {
+			return 0, err
+		}
+
+		var costBasis float64
+		switch method {
+		case MethodFIFO:
+			costBasis, open, err = consumeFIFO(profile, open, toSell)
+		case MethodAverage:
+			costBasis, open, err = consumeAverage(profile, open, toSell)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		realized += proceeds - costBasis
+	}
+
+	return realized, nil
+}
+
+// toBaseCurrency converts amount from currency into profile.BaseCurrency
+// using the installed FXConverter, defaulting to an identity conversion
+// when none was configured.
+func (profile *Profile) toBaseCurrency(amount float64, currency string) (float64, error) {
+	converter := profile.fxConverter
+	if converter == nil {
+		converter = identityFXConverter{}
+	}
+	return converter.Convert(amount, currency, profile.BaseCurrency)
+}
+
+// consumeFIFO removes count shares from the front of open (oldest lots
+// first), returning their total cost basis converted to BaseCurrency and
+// the remaining open lots.
+func consumeFIFO(profile *Profile, open []Lot, count int) (float64, []Lot, error) {
+	totalCount := 0
+	for _, lot := range open {
+		totalCount += lot.Count
+	}
+	if totalCount < count {
+		return 0, open, fmt.Errorf(`mop: cannot sell %d shares, only %d open`, count, totalCount)
+	}
+
+	costBasis := 0.0
+	for count > 0 {
+		lot := &open[0]
+		used := lot.Count
+		if used > count {
+			used = count
+		}
+
+		// lot.Fee always holds the fee remaining on lot.Count not-yet-sold
+		// shares, so this proration never double-charges a lot consumed
+		// across more than one sell.
+		feeConsumed := lot.Fee * float64(used) / float64(lot.Count)
+
+		cost, err := profile.toBaseCurrency(lot.Price*float64(used)+feeConsumed, lot.Currency)
+		if err != nil {
+			return 0, open, err
+		}
+		costBasis += cost
+
+		lot.Count -= used
+		lot.Fee -= feeConsumed
+		count -= used
+		if lot.Count == 0 {
+			open = open[1:]
+		}
+	}
+	return costBasis, open, nil
+}
+
+// consumeAverage removes count shares at a single blended cost per share
+// -- total cost of every open lot divided by its total count -- rather
+// than each lot's own price, so lots bought at different prices are
+// averaged together instead of depleted FIFO. The unsold remainder is
+// collapsed into one synthetic lot carrying that same blended price, so a
+// later sell re-blends from the correct average instead of drifting back
+// toward whichever lot happened to be depleted last. It returns the
+// resulting cost basis converted to BaseCurrency and the remaining open
+// lots.
+func consumeAverage(profile *Profile, open []Lot, count int) (float64, []Lot, error) {
+	totalCount := 0
+	totalCost := 0.0 // Share cost, in BaseCurrency, excluding fees.
+	totalFee := 0.0  // Fees, in BaseCurrency.
+	for _, lot := range open {
+		totalCount += lot.Count
+
+		cost, err := profile.toBaseCurrency(lot.Price*float64(lot.Count), lot.Currency)
+		if err != nil {
+			return 0, open, err
+		}
+		fee, err := profile.toBaseCurrency(lot.Fee, lot.Currency)
+		if err != nil {
+			return 0, open, err
+		}
+		totalCost += cost
+		totalFee += fee
+	}
+	if totalCount < count {
+		return 0, open, fmt.Errorf(`mop: cannot sell %d shares, only %d open`, count, totalCount)
+	}
+	if totalCount == 0 {
+		return 0, open, nil
+	}
+
+	perShareCost := totalCost / float64(totalCount)
+	perShareFee := totalFee / float64(totalCount)
+	costBasis := (perShareCost + perShareFee) * float64(count)
+
+	remainingCount := totalCount - count
+	if remainingCount == 0 {
+		return costBasis, nil, nil
+	}
+
+	remaining := []Lot{{
+		Price:    perShareCost,
+		Count:    remainingCount,
+		Currency: profile.BaseCurrency,
+		Fee:      perShareFee * float64(remainingCount),
+	}}
+
+	return costBasis, remaining, nil
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2013-2019 by Michael Dvorkin and contributors. All Rights Reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package mop
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestProfile() *Profile {
+	return &Profile{
+		BaseCurrency: `USD`,
+		Shares:       make(map[string]*Share),
+		fxConverter:  identityFXConverter{},
+	}
+}
+
+func date(seconds int64) time.Time {
+	return time.Unix(seconds, 0)
+}
+
+func TestRealizedPnLFIFO(t *testing.T) {
+	profile := newTestProfile()
+	profile.Shares[`AAPL`] = &Share{Lots: []Lot{
+		{Date: date(1), Price: 1, Count: 10, Currency: `USD`},
+		{Date: date(2), Price: 3, Count: 10, Currency: `USD`},
+		{Date: date(3), Price: 5, Count: -10, Currency: `USD`},
+	}}
+
+	got, err := profile.RealizedPnL(`AAPL`, MethodFIFO)
+	if err != nil {
+		t.Fatalf(`RealizedPnL: %v`, err)
+	}
+	// Sells the 10 shares bought @$1 first: proceeds 50 - cost 10 = 40.
+	if want := 40.0; got != want {
+		t.Fatalf(`RealizedPnL(fifo) = %v, want %v`, got, want)
+	}
+}
+
+func TestRealizedPnLAverageBlendsCost(t *testing.T) {
+	profile := newTestProfile()
+	profile.Shares[`AAPL`] = &Share{Lots: []Lot{
+		{Date: date(1), Price: 1, Count: 10, Currency: `USD`},
+		{Date: date(2), Price: 3, Count: 10, Currency: `USD`},
+		{Date: date(3), Price: 5, Count: -10, Currency: `USD`},
+	}}
+
+	got, err := profile.RealizedPnL(`AAPL`, MethodAverage)
+	if err != nil {
+		t.Fatalf(`RealizedPnL: %v`, err)
+	}
+	// Blended cost is (10*1 + 10*3) / 20 = 2/share, so selling 10 costs 20,
+	// not the 10 FIFO would charge for the same sale.
+	if want := 30.0; got != want {
+		t.Fatalf(`RealizedPnL(average) = %v, want %v`, got, want)
+	}
+}
+
+func TestRealizedPnLAverageStaysBlendedAcrossSells(t *testing.T) {
+	profile := newTestProfile()
+	profile.Shares[`AAPL`] = &Share{Lots: []Lot{
+		{Date: date(1), Price: 1, Count: 10, Currency: `USD`},
+		{Date: date(2), Price: 3, Count: 10, Currency: `USD`},
+		{Date: date(3), Price: 2, Count: -10, Currency: `USD`},
+		{Date: date(4), Price: 2, Count: -10, Currency: `USD`},
+	}}
+
+	got, err := profile.RealizedPnL(`AAPL`, MethodAverage)
+	if err != nil {
+		t.Fatalf(`RealizedPnL: %v`, err)
+	}
+	// Both sells are at the $2/sh average cost, so each realizes $0 -- that
+	// only holds if the first sell leaves the remaining 10 shares still
+	// priced at the $2 average rather than drifting toward whichever lot
+	// was depleted last.
+	if want := 0.0; got != want {
+		t.Fatalf(`RealizedPnL(average) = %v, want %v`, got, want)
+	}
+}
+
+func TestRealizedPnLFeeProratedAcrossMultipleSells(t *testing.T) {
+	for _, method := range []string{MethodFIFO, MethodAverage} {
+		profile := newTestProfile()
+		profile.Shares[`AAPL`] = &Share{Lots: []Lot{
+			{Date: date(1), Price: 10, Count: 10, Currency: `USD`, Fee: 10},
+			{Date: date(2), Price: 10, Count: -5, Currency: `USD`},
+			{Date: date(3), Price: 10, Count: -5, Currency: `USD`},
+		}}
+
+		got, err := profile.RealizedPnL(`AAPL`, method)
+		if err != nil {
+			t.Fatalf(`%s: RealizedPnL: %v`, method, err)
+		}
+		// Bought 10 @ $10 with a single $10 fee; sold all 10 @ $10 across two
+		// sells. Total proceeds 100, total cost 100 + 10 fee => pnl -10,
+		// regardless of how the sells were split.
+		if want := -10.0; got != want {
+			t.Fatalf(`%s: RealizedPnL = %v, want %v (fee must not be double-charged)`, method, got, want)
+		}
+	}
+}
+
+func TestRealizedPnLRejectsOversell(t *testing.T) {
+	for _, method := range []string{MethodFIFO, MethodAverage} {
+		profile := newTestProfile()
+		profile.Shares[`AAPL`] = &Share{Lots: []Lot{
+			{Date: date(1), Price: 1, Count: 10, Currency: `USD`},
+			{Date: date(2), Price: 1, Count: -15, Currency: `USD`},
+		}}
+
+		if _, err := profile.RealizedPnL(`AAPL`, method); err == nil {
+			t.Fatalf(`%s: expected an error selling more shares than were ever bought`, method)
+		}
+	}
+}
+
+func TestRealizedPnLUnknownMethod(t *testing.T) {
+	profile := newTestProfile()
+	profile.Shares[`AAPL`] = &Share{Lots: []Lot{{Date: date(1), Price: 1, Count: 10, Currency: `USD`}}}
+
+	if _, err := profile.RealizedPnL(`AAPL`, `bogus`); err == nil {
+		t.Fatalf(`expected an error for an unknown cost-basis method`)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2013-2019 by Michael Dvorkin and contributors. All Rights Reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package mop
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// currentSchemaVersion is the Profile shape this binary understands. Bump
+// it and register a transform in schemaMigrations whenever a persisted
+// field's shape changes in a way json.Unmarshal can't absorb on its own --
+// purely additive fields (like Provider, Lots, NamedFilters) don't need
+// one, since Go already zero-values them when they're absent from an
+// older ~/.moprc.
+const currentSchemaVersion = 1
+
+// schemaMigrations maps a schema version to the transform that upgrades a
+// raw profile payload from that version to the next one.
+var schemaMigrations = map[int]func([]byte) ([]byte, error){}
+
+// migrate upgrades raw, a ~/.moprc payload at schema version `from`, to
+// `to` by applying each version's registered transform in turn.
+func migrate(from, to int, raw []byte) ([]byte, error) {
+	data := raw
+	for version := from; version < to; version++ {
+		transform, registered := schemaMigrations[version]
+		if !registered {
+			continue
+		}
+
+		migrated, err := transform(data)
+		if err != nil {
+			return nil, fmt.Errorf(`mop: migration from schema v%d to v%d failed: %v`, version, version+1, err)
+		}
+		data = migrated
+	}
+
+	return data, nil
+}
+
+// detectSchemaVersion reads just the SchemaVersion field out of raw,
+// defaulting to 0 (the pre-schemaVersion shape) when it's missing or the
+// payload doesn't even parse as JSON -- in the latter case migrate is a
+// no-op and the subsequent json.Unmarshal is what reports the corruption.
+func detectSchemaVersion(raw []byte) int {
+	var partial struct {
+		SchemaVersion int
+	}
+	json.Unmarshal(raw, &partial)
+	return partial.SchemaVersion
+}
+
+// backupCorruptProfile copies a ~/.moprc payload that failed to unmarshal
+// aside to ~/.moprc.bak.<unix-timestamp> so the user's prior settings
+// aren't silently discarded and can be inspected or restored by hand.
+func backupCorruptProfile(filename string, raw []byte) error {
+	backupName := fmt.Sprintf(`%s.bak.%d`, filename, time.Now().Unix())
+	return ioutil.WriteFile(backupName, raw, 0644)
+}
+
+// atomicWriteFile writes data to filename without ever leaving a
+// partially-written file in its place: it takes an advisory lock on
+// filename+".lock" to keep concurrent mop instances from racing, writes
+// to a temp file in the same directory, and renames the temp file over
+// filename -- rename is atomic within a filesystem, so a crash mid-write
+// can only ever lose the in-flight save, never corrupt the existing one.
+func atomicWriteFile(filename string, data []byte, perm os.FileMode) error {
+	lock, err := os.OpenFile(filename+`.lock`, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	if err := lockFile(lock); err != nil {
+		return err
+	}
+	defer unlockFile(lock)
+
+	dir := filepath.Dir(filename)
+	temp, err := ioutil.TempFile(dir, filepath.Base(filename)+`.tmp`)
+	if err != nil {
+		return err
+	}
+	tempName := temp.Name()
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(tempName)
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(tempName)
+		return err
+	}
+	if err := os.Chmod(tempName, perm); err != nil {
+		os.Remove(tempName)
+		return err
+	}
+
+	return os.Rename(tempName, filename)
+}
@@ -6,6 +6,7 @@ package mop
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"sort"
 
@@ -15,32 +16,43 @@ import (
 type Share struct {
 	Trade float64
 	Count int
+	Lots  []Lot // Purchase/sale history backing cost-basis and P&L calculations.
 }
 
 // Profile manages Mop program settings as defined by user (ex. list of
 // stock tickers). The settings are serialized using JSON and saved in
 // the ~/.moprc file.
 type Profile struct {
-	Tickers       []string          // List of stock tickers to display.
-	Shares        map[string]*Share // Ticker to share
-	MarketRefresh int               // Time interval to refresh market data.
-	QuotesRefresh int               // Time interval to refresh stock quotes.
-	SortColumn    int               // Column number by which we sort stock quotes.
-	Ascending     bool              // True when sort order is ascending.
-	Grouped       bool              // True when stocks are grouped by advancing/declining.
-	Filter        string            // Filter in human form
-	ApiUrl        string            // API url of finance service
-	ApiUrlParts   string            // API url parts for parameters
+	SchemaVersion   int               // Shape of this persisted profile; see migrate() in persistence.go.
+	Tickers         []string          // Legacy flat ticker list; superseded by Watchlists, kept for migration.
+	Shares          map[string]*Share // Ticker to share
+	MarketRefresh   int               // Time interval to refresh market data.
+	QuotesRefresh   int               // Time interval to refresh stock quotes.
+	SortColumn      int               // Legacy sort column; superseded by Watchlists, kept for migration.
+	Ascending       bool              // Legacy sort order; superseded by Watchlists, kept for migration.
+	Grouped         bool              // True when stocks are grouped by advancing/declining.
+	Filter          string            // Legacy filter in human form; superseded by Watchlists, kept for migration.
+	ApiUrl          string            // API url of finance service (legacy Yahoo-only field, kept for migration)
+	ApiUrlParts     string            // API url parts for parameters (legacy Yahoo-only field, kept for migration)
+	Provider        string            // Quote provider id, e.g. "yahoo", "finnhub", "iex", "alphavantage".
+	Credentials     map[string]string // Per-provider settings: API keys, base urls, etc.
+	BaseCurrency    string            // Currency consolidated portfolio totals are displayed in, e.g. "EUR".
+	NamedFilters    map[string]string // Saved filter presets keyed by user-chosen name.
+	Watchlists      []Watchlist       // Named, independently filterable/sortable sets of tickers.
+	ActiveWatchlist string            // Name of the Watchlist that AddTickers/RemoveTickers/Reorder/SetFilter act on.
 
 	tickersAll       []string                       //Tickers and Share Tickers
 	filterExpression *govaluate.EvaluableExpression // The filter as a govaluate expression
 	selectedColumn   int                            // Stores selected column number when the column editor is active.
 	filename         string                         // Path to the file in which the configuration is stored
+	fxConverter      FXConverter                    // Converts lot currencies into BaseCurrency; defaults to identityFXConverter.
 }
 
 // Creates the profile and attempts to load the settings from ~/.moprc file.
-// If the file is not there it gets created with default values.
-func NewProfile(filename string, region string) *Profile {
+// If the file is not there it gets created with default values. If the
+// file is there but corrupt, NewProfile backs it up and returns an error
+// rather than silently resetting the user's settings.
+func NewProfile(filename string, region string) (*Profile, error) {
 	profile := &Profile{filename: filename}
 	data, err := ioutil.ReadFile(filename)
 	if err != nil { // Set default values:
@@ -61,26 +73,78 @@ func NewProfile(filename string, region string) *Profile {
 		profile.SortColumn = 0   // Stock quotes are sorted by ticker name.
 		profile.Ascending = true // A to Z.
 		profile.Filter = ""
+		profile.BaseCurrency = `USD`
 		profile.ApiUrl = `https://query1.finance.yahoo.com/v7/finance/quote?symbols=%s`
 		if region == "de" {
 			profile.ApiUrlParts = `&range=1d&interval=5m&indicators=close&includeTimestamps=false&includePrePost=false&region=DE&lang=de-DE&corsDomain=de.finance.yahoo.com&.tsrc=finance`
 		} else {
 			profile.ApiUrlParts = `&range=1d&interval=5m&indicators=close&includeTimestamps=false&includePrePost=false&corsDomain=finance.yahoo.com&.tsrc=finance`
 		}
-		profile.Save()
+		profile.migrateProvider()
+		profile.migrateWatchlists()
+		profile.SchemaVersion = currentSchemaVersion
+		if err := profile.Save(); err != nil {
+			return nil, err
+		}
 	} else {
-		json.Unmarshal(data, profile)
-		profile.SetFilter(profile.Filter)
+		migrated, err := migrate(detectSchemaVersion(data), currentSchemaVersion, data)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(migrated, profile); err != nil {
+			if backupErr := backupCorruptProfile(filename, data); backupErr != nil {
+				return nil, fmt.Errorf(`mop: profile %s is corrupt (%v) and could not be backed up: %v`, filename, err, backupErr)
+			}
+			return nil, fmt.Errorf(`mop: profile %s is corrupt and has been backed up: %v`, filename, err)
+		}
+
+		profile.migrateWatchlists()
+		if err := profile.SetFilter(profile.activeWatchlist().Filter); err != nil {
+			// A filter that no longer validates must not make the profile
+			// unusable: drop it and keep loading.
+			profile.activeWatchlist().Filter = ``
+			profile.filterExpression = nil
+		}
+		profile.migrateProvider()
+		if profile.BaseCurrency == `` {
+			profile.BaseCurrency = `USD`
+		}
+		profile.SchemaVersion = currentSchemaVersion
 	}
 	profile.selectedColumn = -1
 	profile.CalculateTickersAll()
+	profile.fxConverter = identityFXConverter{}
 
-	return profile
+	return profile, nil
+}
+
+// migrateProvider populates the Provider/Credentials fields from the
+// legacy ApiUrl/ApiUrlParts fields when a profile predates the
+// QuoteProvider abstraction, so existing ~/.moprc files keep working
+// against the Yahoo backend without user intervention.
+func (profile *Profile) migrateProvider() {
+	if profile.Provider != `` {
+		return
+	}
+
+	profile.Provider = ProviderYahoo
+	if profile.Credentials == nil {
+		profile.Credentials = make(map[string]string)
+	}
+	profile.Credentials[`apiUrl`] = profile.ApiUrl
+	profile.Credentials[`apiUrlParts`] = profile.ApiUrlParts
+}
+
+// QuoteProvider builds the QuoteProvider configured by this profile's
+// Provider/Credentials fields.
+func (profile *Profile) QuoteProvider() (QuoteProvider, error) {
+	return NewQuoteProvider(profile.Provider, profile.Credentials)
 }
 
 func (profile *Profile) CalculateTickersAll() {
 	tickers := make(map[string]bool)
-	for _, tracker := range profile.Tickers {
+	for _, tracker := range profile.activeWatchlist().Tickers {
 		tickers[tracker] = true
 	}
 	for ticker, _ := range profile.Shares {
@@ -92,26 +156,31 @@ func (profile *Profile) CalculateTickersAll() {
 	}
 }
 
-// Save serializes settings using JSON and saves them in ~/.moprc file.
+// Save serializes settings using JSON and atomically saves them in the
+// ~/.moprc file: the new contents are written to a temp file under an
+// advisory lock and then renamed into place, so a crash or a second mop
+// instance saving concurrently can't leave ~/.moprc half-written.
 func (profile *Profile) Save() error {
 	profile.CalculateTickersAll()
+	profile.SchemaVersion = currentSchemaVersion
 
 	data, err := json.Marshal(profile)
 	if err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(profile.filename, data, 0644)
+	return atomicWriteFile(profile.filename, data, 0644)
 }
 
-// AddTickers updates the list of existing tikers to add the new ones making
-// sure there are no duplicates.
+// AddTickers updates the active watchlist's tickers to add the new ones
+// making sure there are no duplicates.
 func (profile *Profile) AddTickers(tickers []string) (added int, err error) {
 	added, err = 0, nil
+	watchlist := profile.activeWatchlist()
 	existing := make(map[string]bool)
 
 	// Build a hash of existing tickers so we could look it up quickly.
-	for _, ticker := range profile.Tickers {
+	for _, ticker := range watchlist.Tickers {
 		existing[ticker] = true
 	}
 
@@ -119,27 +188,29 @@ func (profile *Profile) AddTickers(tickers []string) (added int, err error) {
 	// already exist.
 	for _, ticker := range tickers {
 		if _, found := existing[ticker]; !found {
-			profile.Tickers = append(profile.Tickers, ticker)
+			watchlist.Tickers = append(watchlist.Tickers, ticker)
 			added++
 		}
 	}
 
 	if added > 0 {
-		sort.Strings(profile.Tickers)
+		sort.Strings(watchlist.Tickers)
 		err = profile.Save()
 	}
 
 	return
 }
 
-// RemoveTickers removes requested stock tickers from the list we track.
+// RemoveTickers removes requested stock tickers from the active
+// watchlist.
 func (profile *Profile) RemoveTickers(tickers []string) (removed int, err error) {
 	removed, err = 0, nil
+	watchlist := profile.activeWatchlist()
 	for _, ticker := range tickers {
-		for i, existing := range profile.Tickers {
+		for i, existing := range watchlist.Tickers {
 			if ticker == existing {
 				// Requested ticker is there: remove i-th slice item.
-				profile.Tickers = append(profile.Tickers[:i], profile.Tickers[i+1:]...)
+				watchlist.Tickers = append(watchlist.Tickers[:i], watchlist.Tickers[i+1:]...)
 				removed++
 			}
 		}
@@ -153,12 +224,14 @@ func (profile *Profile) RemoveTickers(tickers []string) (removed int, err error)
 }
 
 // Reorder gets called by the column editor to either reverse sorting order
-// for the current column, or to pick another sort column.
+// for the current column, or to pick another sort column, on the active
+// watchlist.
 func (profile *Profile) Reorder() error {
-	if profile.selectedColumn == profile.SortColumn {
-		profile.Ascending = !profile.Ascending // Reverse sort order.
+	watchlist := profile.activeWatchlist()
+	if profile.selectedColumn == watchlist.SortColumn {
+		watchlist.Ascending = !watchlist.Ascending // Reverse sort order.
 	} else {
-		profile.SortColumn = profile.selectedColumn // Pick new sort column.
+		watchlist.SortColumn = profile.selectedColumn // Pick new sort column.
 	}
 	return profile.Save()
 }
@@ -170,20 +243,22 @@ func (profile *Profile) Regroup() error {
 	return profile.Save()
 }
 
-// SetFilter creates a govaluate.EvaluableExpression.
-func (profile *Profile) SetFilter(filter string) {
-	if len(filter) > 0 {
-		var err error
-		profile.filterExpression, err = govaluate.NewEvaluableExpression(filter)
-
-		if err != nil {
-			panic(err)
-		}
+// SetFilter validates filter against the declared filter variable schema
+// and, if it parses cleanly, compiles it into a govaluate.EvaluableExpression
+// and persists it on the active watchlist. An invalid filter is rejected
+// with an error instead of being written to disk, so it can never render
+// the profile unloadable.
+func (profile *Profile) SetFilter(filter string) error {
+	if err := profile.ValidateFilter(filter); err != nil {
+		return err
+	}
 
-	} else if len(filter) == 0 && profile.filterExpression != nil {
+	if len(filter) > 0 {
+		profile.filterExpression, _ = govaluate.NewEvaluableExpressionWithFunctions(filter, filterFunctions)
+	} else {
 		profile.filterExpression = nil
 	}
 
-	profile.Filter = filter
-	profile.Save()
+	profile.activeWatchlist().Filter = filter
+	return profile.Save()
 }
@@ -0,0 +1,112 @@
+// Copyright (c) 2013-2019 by Michael Dvorkin and contributors. All Rights Reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package mop
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateFilterRejectsUnknownIdentifier(t *testing.T) {
+	profile := newTestProfile()
+
+	if err := profile.ValidateFilter(`price > 10`); err != nil {
+		t.Fatalf(`ValidateFilter(known identifier) = %v, want nil`, err)
+	}
+	if err := profile.ValidateFilter(`price > bogus_field`); err == nil {
+		t.Fatalf(`ValidateFilter(unknown identifier) = nil, want an error`)
+	}
+}
+
+func TestNewProfileClearsAnInvalidPersistedFilter(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), `.moprc`)
+
+	// A persisted profile whose filter references a field the current
+	// schema doesn't recognize anymore -- e.g. left over from an older
+	// mop version.
+	raw := `{
+		"SchemaVersion": 1,
+		"Tickers": ["AAPL"],
+		"Filter": "price > bogus_field",
+		"Provider": "yahoo",
+		"Watchlists": [{"Name": "Default", "Tickers": ["AAPL"], "Filter": "price > bogus_field"}],
+		"ActiveWatchlist": "Default"
+	}`
+	if err := os.WriteFile(filename, []byte(raw), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	profile, err := NewProfile(filename, `us`)
+	if err != nil {
+		t.Fatalf(`NewProfile returned an error for a profile with a stale filter: %v`, err)
+	}
+	if got := profile.activeWatchlist().Filter; got != `` {
+		t.Fatalf(`activeWatchlist().Filter = %q, want empty (the invalid filter should be dropped)`, got)
+	}
+}
+
+func TestProfileSaveAndReloadRoundTrips(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), `.moprc`)
+
+	profile, err := NewProfile(filename, `us`)
+	if err != nil {
+		t.Fatalf(`NewProfile: %v`, err)
+	}
+
+	if _, err := profile.AddTickers([]string{`ZZZZ`}); err != nil {
+		t.Fatalf(`AddTickers: %v`, err)
+	}
+	if err := profile.SetFilter(`price > 10`); err != nil {
+		t.Fatalf(`SetFilter: %v`, err)
+	}
+
+	reloaded, err := NewProfile(filename, `us`)
+	if err != nil {
+		t.Fatalf(`NewProfile (reload): %v`, err)
+	}
+
+	found := false
+	for _, ticker := range reloaded.activeWatchlist().Tickers {
+		if ticker == `ZZZZ` {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf(`reloaded watchlist tickers %v do not contain ZZZZ`, reloaded.activeWatchlist().Tickers)
+	}
+	if want := `price > 10`; reloaded.activeWatchlist().Filter != want {
+		t.Fatalf(`reloaded filter = %q, want %q`, reloaded.activeWatchlist().Filter, want)
+	}
+	if reloaded.SchemaVersion != currentSchemaVersion {
+		t.Fatalf(`reloaded SchemaVersion = %d, want %d`, reloaded.SchemaVersion, currentSchemaVersion)
+	}
+}
+
+func TestNewProfileBacksUpAndErrorsOnCorruptFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), `.moprc`)
+
+	if err := os.WriteFile(filename, []byte(`{not valid json`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewProfile(filename, `us`); err == nil {
+		t.Fatalf(`NewProfile = nil error, want an error for a corrupt profile`)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backedUp := false
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != `` && entry.Name() != filepath.Base(filename) {
+			backedUp = true
+		}
+	}
+	if !backedUp {
+		t.Fatalf(`expected a backup file alongside %s, found: %v`, filename, entries)
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright (c) 2013-2019 by Michael Dvorkin and contributors. All Rights Reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package mop
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// Provider name constants used by Profile.Provider and the QuoteProvider
+// factory below.
+const (
+	ProviderYahoo        = `yahoo`
+	ProviderFinnhub      = `finnhub`
+	ProviderIEX          = `iex`
+	ProviderAlphaVantage = `alphavantage`
+)
+
+// Quote is a minimal snapshot of a single ticker's market data as returned
+// by a QuoteProvider. It deliberately carries only a small common set of
+// fields; richer per-provider data is not surfaced here. A backend whose
+// response lacks one of these fields leaves it zero (Finnhub's quote
+// carries no volume, so FinnhubProvider never sets Volume).
+type Quote struct {
+	Ticker    string
+	LastTrade float64
+	Change    float64
+	ChangePct float64
+	Volume    int64
+}
+
+// QuoteProvider fetches stock quotes from a market data backend. Concrete
+// implementations hide the differences between upstream APIs (auth,
+// request shape, response format) behind a common interface so Profile can
+// switch backends -- or tests can supply a double -- without touching the
+// rest of mop.
+type QuoteProvider interface {
+	// Fetch retrieves quotes for the given tickers.
+	Fetch(tickers []string) ([]Quote, error)
+	// Name returns the provider's short identifier, e.g. "yahoo".
+	Name() string
+	// SupportsRealtime reports whether quotes are live (true) or delayed.
+	SupportsRealtime() bool
+}
+
+// NewQuoteProvider builds the QuoteProvider named by `name`, configured
+// with the given credentials (API keys, base URLs, and anything else the
+// provider needs). An unknown name is an error rather than a silent
+// fallback so a typo in ~/.moprc surfaces immediately.
+func NewQuoteProvider(name string, credentials map[string]string) (QuoteProvider, error) {
+	switch name {
+	case ProviderYahoo:
+		return &YahooProvider{credentials: credentials}, nil
+	case ProviderFinnhub:
+		return &FinnhubProvider{credentials: credentials}, nil
+	case ProviderIEX:
+		return &IEXProvider{credentials: credentials}, nil
+	case ProviderAlphaVantage:
+		return &AlphaVantageProvider{credentials: credentials}, nil
+	default:
+		return nil, fmt.Errorf(`mop: unknown quote provider %q`, name)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Yahoo Finance
+//-----------------------------------------------------------------------------
+
+// YahooProvider talks to the (unofficial, frequently-changing) Yahoo
+// Finance quote endpoint. credentials["apiUrl"] and credentials["apiUrlParts"]
+// mirror the legacy Profile.ApiUrl/ApiUrlParts fields.
+type YahooProvider struct {
+	credentials map[string]string
+}
+
+func (provider *YahooProvider) Name() string {
+	return ProviderYahoo
+}
+
+func (provider *YahooProvider) SupportsRealtime() bool {
+	return false
+}
+
+func (provider *YahooProvider) Fetch(tickers []string) ([]Quote, error) {
+	apiUrl := provider.credentials[`apiUrl`]
+	if apiUrl == `` {
+		return nil, fmt.Errorf(`mop: yahoo provider is missing "apiUrl" credential`)
+	}
+
+	url := fmt.Sprintf(apiUrl, joinTickers(tickers)) + provider.credentials[`apiUrlParts`]
+	body, err := httpGet(url)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		QuoteResponse struct {
+			Result []struct {
+				Symbol                     string  `json:"symbol"`
+				RegularMarketPrice         float64 `json:"regularMarketPrice"`
+				RegularMarketChange        float64 `json:"regularMarketChange"`
+				RegularMarketChangePercent float64 `json:"regularMarketChangePercent"`
+				RegularMarketVolume        int64   `json:"regularMarketVolume"`
+			} `json:"result"`
+		} `json:"quoteResponse"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+
+	quotes := make([]Quote, 0, len(response.QuoteResponse.Result))
+	for _, result := range response.QuoteResponse.Result {
+		quotes = append(quotes, Quote{
+			Ticker:    result.Symbol,
+			LastTrade: result.RegularMarketPrice,
+			Change:    result.RegularMarketChange,
+			ChangePct: result.RegularMarketChangePercent,
+			Volume:    result.RegularMarketVolume,
+		})
+	}
+
+	return quotes, nil
+}
+
+//-----------------------------------------------------------------------------
+// Finnhub
+//-----------------------------------------------------------------------------
+
+// FinnhubProvider talks to the Finnhub.io /quote endpoint, which is
+// single-ticker per request, so Fetch issues one call per ticker.
+// credentials["token"] holds the Finnhub API key.
+type FinnhubProvider struct {
+	credentials map[string]string
+}
+
+func (provider *FinnhubProvider) Name() string {
+	return ProviderFinnhub
+}
+
+func (provider *FinnhubProvider) SupportsRealtime() bool {
+	return true
+}
+
+func (provider *FinnhubProvider) Fetch(tickers []string) ([]Quote, error) {
+	token := provider.credentials[`token`]
+	if token == `` {
+		return nil, fmt.Errorf(`mop: finnhub provider is missing "token" credential`)
+	}
+
+	baseUrl := provider.credentials[`apiUrl`]
+	if baseUrl == `` {
+		baseUrl = `https://finnhub.io/api/v1/quote`
+	}
+
+	quotes := make([]Quote, 0, len(tickers))
+	for _, ticker := range tickers {
+		url := fmt.Sprintf(`%s?symbol=%s&token=%s`, baseUrl, ticker, token)
+		body, err := httpGet(url)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			CurrentPrice  float64 `json:"c"`
+			Change        float64 `json:"d"`
+			ChangePercent float64 `json:"dp"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+
+		quotes = append(quotes, Quote{
+			Ticker:    ticker,
+			LastTrade: result.CurrentPrice,
+			Change:    result.Change,
+			ChangePct: result.ChangePercent,
+		})
+	}
+
+	return quotes, nil
+}
+
+//-----------------------------------------------------------------------------
+// IEX Cloud
+//-----------------------------------------------------------------------------
+
+// IEXProvider talks to the IEX Cloud /stock/{symbol}/quote endpoint.
+// credentials["token"] holds the publishable IEX Cloud API token.
+type IEXProvider struct {
+	credentials map[string]string
+}
+
+func (provider *IEXProvider) Name() string {
+	return ProviderIEX
+}
+
+func (provider *IEXProvider) SupportsRealtime() bool {
+	return true
+}
+
+func (provider *IEXProvider) Fetch(tickers []string) ([]Quote, error) {
+	token := provider.credentials[`token`]
+	if token == `` {
+		return nil, fmt.Errorf(`mop: iex provider is missing "token" credential`)
+	}
+
+	baseUrl := provider.credentials[`apiUrl`]
+	if baseUrl == `` {
+		baseUrl = `https://cloud.iexapis.com/stable/stock`
+	}
+
+	quotes := make([]Quote, 0, len(tickers))
+	for _, ticker := range tickers {
+		url := fmt.Sprintf(`%s/%s/quote?token=%s`, baseUrl, ticker, token)
+		body, err := httpGet(url)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Symbol        string  `json:"symbol"`
+			LatestPrice   float64 `json:"latestPrice"`
+			Change        float64 `json:"change"`
+			ChangePercent float64 `json:"changePercent"`
+			Volume        int64   `json:"latestVolume"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+
+		quotes = append(quotes, Quote{
+			Ticker:    result.Symbol,
+			LastTrade: result.LatestPrice,
+			Change:    result.Change,
+			ChangePct: result.ChangePercent * 100,
+			Volume:    result.Volume,
+		})
+	}
+
+	return quotes, nil
+}
+
+//-----------------------------------------------------------------------------
+// Alpha Vantage
+//-----------------------------------------------------------------------------
+
+// AlphaVantageProvider talks to the Alpha Vantage GLOBAL_QUOTE endpoint,
+// which like Finnhub is single-ticker per request. Alpha Vantage's free
+// tier is aggressively rate-limited, so callers should keep watchlists
+// small when using this provider. credentials["apikey"] holds the API key.
+type AlphaVantageProvider struct {
+	credentials map[string]string
+}
+
+func (provider *AlphaVantageProvider) Name() string {
+	return ProviderAlphaVantage
+}
+
+func (provider *AlphaVantageProvider) SupportsRealtime() bool {
+	return false
+}
+
+func (provider *AlphaVantageProvider) Fetch(tickers []string) ([]Quote, error) {
+	apikey := provider.credentials[`apikey`]
+	if apikey == `` {
+		return nil, fmt.Errorf(`mop: alphavantage provider is missing "apikey" credential`)
+	}
+
+	baseUrl := provider.credentials[`apiUrl`]
+	if baseUrl == `` {
+		baseUrl = `https://www.alphavantage.co/query`
+	}
+
+	quotes := make([]Quote, 0, len(tickers))
+	for _, ticker := range tickers {
+		url := fmt.Sprintf(`%s?function=GLOBAL_QUOTE&symbol=%s&apikey=%s`, baseUrl, ticker, apikey)
+		body, err := httpGet(url)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			GlobalQuote struct {
+				Symbol        string `json:"01. symbol"`
+				Price         string `json:"05. price"`
+				Change        string `json:"09. change"`
+				ChangePercent string `json:"10. change percent"`
+				Volume        string `json:"06. volume"`
+			} `json:"Global Quote"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+
+		quotes = append(quotes, Quote{
+			Ticker:    ticker,
+			LastTrade: parseFloat(result.GlobalQuote.Price),
+			Change:    parseFloat(result.GlobalQuote.Change),
+			ChangePct: parsePercent(result.GlobalQuote.ChangePercent),
+			Volume:    int64(parseFloat(result.GlobalQuote.Volume)),
+		})
+	}
+
+	return quotes, nil
+}
+
+//-----------------------------------------------------------------------------
+// Shared helpers
+//-----------------------------------------------------------------------------
+
+// joinTickers renders tickers the way Yahoo's quote endpoint expects them:
+// a single comma-separated string.
+func joinTickers(tickers []string) string {
+	joined := ``
+	for i, ticker := range tickers {
+		if i > 0 {
+			joined += `,`
+		}
+		joined += ticker
+	}
+	return joined
+}
+
+// httpGet fetches url and returns the response body, treating any status
+// code other than 200 OK as an error.
+func httpGet(url string) ([]byte, error) {
+	response, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(`mop: %s returned status %s`, url, response.Status)
+	}
+
+	return ioutil.ReadAll(response.Body)
+}
+
+// parseFloat parses a numeric string returned by a JSON API, treating a
+// malformed value as zero rather than failing the whole quote.
+func parseFloat(value string) float64 {
+	result, _ := strconv.ParseFloat(value, 64)
+	return result
+}
+
+// parsePercent parses a "-1.23%"-style string into -1.23.
+func parsePercent(value string) float64 {
+	trimmed := value
+	if len(trimmed) > 0 && trimmed[len(trimmed)-1] == '%' {
+		trimmed = trimmed[:len(trimmed)-1]
+	}
+	return parseFloat(trimmed)
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2013-2019 by Michael Dvorkin and contributors. All Rights Reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package mop
+
+import "fmt"
+
+// defaultWatchlistName is the watchlist an older ~/.moprc's flat
+// Tickers/Filter/SortColumn/Ascending fields are migrated into.
+const defaultWatchlistName = `Default`
+
+// Watchlist is a named, independently filterable and sortable set of
+// tickers. A Profile always has at least one; AddTickers, RemoveTickers,
+// Reorder, and SetFilter all act on whichever watchlist is active.
+type Watchlist struct {
+	Name       string
+	Tickers    []string
+	Filter     string
+	SortColumn int
+	Ascending  bool
+}
+
+// migrateWatchlists moves the legacy top-level Tickers/Filter/SortColumn/
+// Ascending fields into a "Default" watchlist the first time a profile
+// without any Watchlists is loaded, so existing ~/.moprc files keep
+// working unchanged.
+func (profile *Profile) migrateWatchlists() {
+	if len(profile.Watchlists) > 0 {
+		return
+	}
+
+	profile.Watchlists = []Watchlist{
+		{
+			Name:       defaultWatchlistName,
+			Tickers:    profile.Tickers,
+			Filter:     profile.Filter,
+			SortColumn: profile.SortColumn,
+			Ascending:  profile.Ascending,
+		},
+	}
+	profile.ActiveWatchlist = defaultWatchlistName
+}
+
+// activeWatchlist returns the watchlist named by profile.ActiveWatchlist,
+// migrating in the legacy fields or falling back to the first watchlist
+// if ActiveWatchlist doesn't (or no longer) match one.
+func (profile *Profile) activeWatchlist() *Watchlist {
+	if len(profile.Watchlists) == 0 {
+		profile.migrateWatchlists()
+	}
+
+	for i := range profile.Watchlists {
+		if profile.Watchlists[i].Name == profile.ActiveWatchlist {
+			return &profile.Watchlists[i]
+		}
+	}
+
+	return &profile.Watchlists[0]
+}
+
+// AddWatchlist creates a new, empty watchlist named `name`.
+func (profile *Profile) AddWatchlist(name string) error {
+	for _, watchlist := range profile.Watchlists {
+		if watchlist.Name == name {
+			return fmt.Errorf(`mop: watchlist %q already exists`, name)
+		}
+	}
+
+	profile.Watchlists = append(profile.Watchlists, Watchlist{Name: name, Ascending: true})
+	return profile.Save()
+}
+
+// RemoveWatchlist deletes the named watchlist. The active watchlist can't
+// be removed out from under itself; switch away from it first.
+func (profile *Profile) RemoveWatchlist(name string) error {
+	if name == profile.ActiveWatchlist {
+		return fmt.Errorf(`mop: cannot remove the active watchlist %q`, name)
+	}
+
+	for i, watchlist := range profile.Watchlists {
+		if watchlist.Name == name {
+			profile.Watchlists = append(profile.Watchlists[:i], profile.Watchlists[i+1:]...)
+			return profile.Save()
+		}
+	}
+
+	return fmt.Errorf(`mop: no watchlist named %q`, name)
+}
+
+// SwitchWatchlist makes the named watchlist active and recompiles its
+// filter expression, so subsequent AddTickers/RemoveTickers/Reorder/
+// SetFilter calls operate on it. This is the backend counterpart of the
+// UI-layer keybinding that cycles through watchlists.
+func (profile *Profile) SwitchWatchlist(name string) error {
+	exists := false
+	for _, watchlist := range profile.Watchlists {
+		if watchlist.Name == name {
+			exists = true
+			break
+		}
+	}
+	if !exists {
+		return fmt.Errorf(`mop: no watchlist named %q`, name)
+	}
+
+	profile.ActiveWatchlist = name
+	return profile.SetFilter(profile.activeWatchlist().Filter)
+}